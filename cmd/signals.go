@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"runtime/pprof"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// withSignalHandling runs fn with a context that is canceled on the first
+// SIGINT/SIGTERM, giving fn a chance to clean up (e.g. removing a
+// partially-created VM via vmRmExceptBoot+rmVolume) before exiting. A
+// second signal is ignored while cleanup is in progress; a third signal
+// skips cleanup entirely and force-exits. SIGQUIT dumps all goroutine
+// stacks, for diagnosing a cleanup that appears to be stuck.
+func withSignalHandling(fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer signal.Stop(sigChan)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(ctx)
+	}()
+
+	interrupts := 0
+	for {
+		select {
+		case err := <-done:
+			return err
+		case sig := <-sigChan:
+			if sig == syscall.SIGQUIT {
+				pprof.Lookup("goroutine").WriteTo(os.Stderr, 1)
+				continue
+			}
+
+			interrupts++
+			switch interrupts {
+			case 1:
+				log.Print("Received interrupt, cleaning up (press Ctrl-C twice more to force exit)")
+				cancel()
+			case 2:
+				log.Print("Received another interrupt, still cleaning up (press Ctrl-C once more to force exit)")
+			default:
+				log.Print("Received third interrupt, forcing exit without cleanup")
+				os.Exit(1)
+			}
+		}
+	}
+}
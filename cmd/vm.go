@@ -0,0 +1,18 @@
+package cmd
+
+import "github.com/spf13/cobra"
+
+// vmCommand groups all "virter vm ..." subcommands.
+func vmCommand() *cobra.Command {
+	vmCmd := &cobra.Command{
+		Use:   "vm",
+		Short: "Manage VMs",
+	}
+
+	vmCmd.AddCommand(vmExecCommand())
+	vmCmd.AddCommand(vmConsoleCommand())
+	vmCmd.AddCommand(vmFleetCommand())
+	vmCmd.AddCommand(vmMeasureCommand())
+
+	return vmCmd
+}
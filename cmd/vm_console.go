@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"io"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+func vmConsoleCommand() *cobra.Command {
+	consoleCmd := &cobra.Command{
+		Use:   "console vm_name",
+		Short: "Attach to a VM's serial console",
+		Long:  `Attach interactively to a VM's serial console, e.g. to diagnose early-boot failures before SSH is up.`,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			vmName := args[0]
+
+			v, err := VirterConnect()
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			console, err := v.VMConsoleAttach(vmName)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer console.Close()
+
+			fd := int(os.Stdin.Fd())
+			state, err := terminal.MakeRaw(fd)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer terminal.Restore(fd, state)
+
+			go io.Copy(console, os.Stdin)
+			if _, err := io.Copy(os.Stdout, console); err != nil && err != io.EOF {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	return consoleCmd
+}
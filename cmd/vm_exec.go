@@ -21,8 +21,6 @@ func vmExecCommand() *cobra.Command {
 			dockerImageName := args[1]
 
 			dockerTimeout := viper.GetDuration("time.docker_timeout")
-			ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
-			defer cancel()
 
 			v, err := VirterConnect()
 			if err != nil {
@@ -40,7 +38,12 @@ func vmExecCommand() *cobra.Command {
 				log.Fatalf("failed to load private key from '%s': %v", privateKeyPath, err)
 			}
 
-			err = v.VMExec(ctx, docker, vmName, dockerImageName, privateKey)
+			err = withSignalHandling(func(ctx context.Context) error {
+				ctx, cancel := context.WithTimeout(ctx, dockerTimeout)
+				defer cancel()
+
+				return v.VMExec(ctx, docker, vmName, dockerImageName, privateKey)
+			})
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/OlfillasOdikno/virter/internal/virter"
+)
+
+// fleetManifest is the on-disk YAML shape of a fleet spec. Unlike
+// virter.FleetSpec it also carries the provisioning steps to run, and it
+// has no SSH keys; toSpec takes those from the caller.
+type fleetManifest struct {
+	NamePrefix  string                         `yaml:"name_prefix"`
+	Count       int                            `yaml:"count"`
+	Image       string                         `yaml:"image"`
+	MemoryKiB   uint64                         `yaml:"memory_kib"`
+	VCPUs       uint                           `yaml:"vcpus"`
+	MaxParallel int                            `yaml:"max_parallel"`
+	BaseVMID    int                            `yaml:"base_vmid"`
+	Overrides   map[int]virter.FleetVMOverride `yaml:"overrides"`
+	Provision   []virter.ProvisionShellStep    `yaml:"provision"`
+}
+
+func (m fleetManifest) toSpec(sshPublicKeys []string) virter.FleetSpec {
+	return virter.FleetSpec{
+		NamePrefix:    m.NamePrefix,
+		Count:         m.Count,
+		ImageName:     m.Image,
+		MemoryKiB:     m.MemoryKiB,
+		VCPUs:         m.VCPUs,
+		MaxParallel:   m.MaxParallel,
+		BaseVMID:      m.BaseVMID,
+		Overrides:     m.Overrides,
+		SSHPublicKeys: sshPublicKeys,
+	}
+}
+
+func vmFleetCommand() *cobra.Command {
+	var keepOnFailure bool
+
+	fleetCmd := &cobra.Command{
+		Use:   "fleet manifest_file",
+		Short: "Bring up a fleet of VMs from a manifest",
+		Long:  `Bring up N VMs described by a YAML fleet manifest in parallel, e.g. to spin up a multi-node test cluster in one invocation.`,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			manifestPath := args[0]
+
+			data, err := ioutil.ReadFile(manifestPath)
+			if err != nil {
+				log.Fatalf("failed to read fleet manifest '%s': %v", manifestPath, err)
+			}
+
+			var manifest fleetManifest
+			if err := yaml.Unmarshal(data, &manifest); err != nil {
+				log.Fatalf("failed to parse fleet manifest '%s': %v", manifestPath, err)
+			}
+
+			v, err := VirterConnect()
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			g, err := virter.NewISOGenerator()
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			waitTimeout := viper.GetDuration("time.ssh_ping_timeout")
+			waiter := virter.NewPortWaiter(waitTimeout)
+
+			privateKeyPath := viper.GetString("auth.virter_private_key_path")
+			privateKey, err := ioutil.ReadFile(privateKeyPath)
+			if err != nil {
+				log.Fatalf("failed to load private key from '%s': %v", privateKeyPath, err)
+			}
+
+			publicKeyPath := viper.GetString("auth.virter_public_key_path")
+			publicKeyData, err := ioutil.ReadFile(publicKeyPath)
+			if err != nil {
+				log.Fatalf("failed to load public key from '%s': %v", publicKeyPath, err)
+			}
+
+			spec := manifest.toSpec(strings.Split(strings.TrimSpace(string(publicKeyData)), "\n"))
+
+			err = withSignalHandling(func(ctx context.Context) error {
+				if runErr := v.VMFleetRun(ctx, g, waiter, spec, true, keepOnFailure); runErr != nil {
+					return runErr
+				}
+
+				for _, step := range manifest.Provision {
+					step := step
+					if runErr := v.VMFleetProvision(ctx, spec, privateKey, &step, keepOnFailure); runErr != nil {
+						return runErr
+					}
+				}
+
+				return nil
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	fleetCmd.Flags().BoolVar(&keepOnFailure, "keep-on-failure", false, "do not tear down the fleet if a VM fails to start or provision")
+
+	return fleetCmd
+}
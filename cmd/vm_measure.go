@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/OlfillasOdikno/virter/internal/virter"
+)
+
+func vmMeasureCommand() *cobra.Command {
+	var outputPath string
+
+	measureCmd := &cobra.Command{
+		Use:   "measure vm_name",
+		Short: "Boot a VM and read its TPM PCR measurements",
+		Long:  `Boot vm_name headless and read the PCR values from its emulated TPM, e.g. to verify a Secure Boot measured boot chain.`,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			vmName := args[0]
+
+			v, err := VirterConnect()
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			privateKeyPath := viper.GetString("auth.virter_private_key_path")
+			privateKey, err := ioutil.ReadFile(privateKeyPath)
+			if err != nil {
+				log.Fatalf("failed to load private key from '%s': %v", privateKeyPath, err)
+			}
+
+			waitTimeout := viper.GetDuration("time.ssh_ping_timeout")
+			waiter := virter.NewPortWaiter(waitTimeout)
+
+			measurements, err := v.VMMeasure(waiter, vmName, privateKey)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			data, err := yaml.Marshal(measurements)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if outputPath == "" {
+				fmt.Print(string(data))
+				return
+			}
+
+			if err := ioutil.WriteFile(outputPath, data, 0644); err != nil {
+				log.Fatalf("failed to write measurements to '%s': %v", outputPath, err)
+			}
+		},
+	}
+
+	measureCmd.Flags().StringVar(&outputPath, "output", "", "write measurements as YAML to this file instead of stdout")
+
+	return measureCmd
+}
@@ -0,0 +1,28 @@
+package virter
+
+// VMConfig describes how to create a single VM.
+type VMConfig struct {
+	ImageName     string
+	VMName        string
+	VMID          int
+	MemoryKiB     uint64
+	VCPUs         uint
+	SSHPublicKeys []string
+
+	// CloudInitUserDataOverlay, if non-empty, is appended to the
+	// rendered user-data so a manifest can inject extra cloud-init
+	// directives (e.g. packages, write_files) without a custom template.
+	CloudInitUserDataOverlay string
+
+	// TPM attaches an emulated TPM 2.0 device (swtpm-backed) to the VM.
+	TPM bool
+
+	// SecureBoot boots the VM via OVMF/UEFI firmware with Secure Boot
+	// enabled. Only meaningful when TPM is also set.
+	SecureBoot bool
+
+	// Backend selects how the VM is created: BackendLibvirt (the
+	// default, a real libvirt domain) or BackendContainer (a Docker
+	// container standing in for a VM, for fast CI runs).
+	Backend string
+}
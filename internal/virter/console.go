@@ -0,0 +1,202 @@
+package virter
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+)
+
+// consoleLogRetention is the maximum size in bytes a console log is allowed
+// to grow to before it is rotated.
+const consoleLogRetention = 10 * 1024 * 1024
+
+// consoleLoggers tracks the background goroutines that copy console output
+// to disk, keyed by VM name. It is protected by consoleLoggersLock because
+// VMRun and VMRm may be called concurrently for different VMs.
+var (
+	consoleLoggersLock sync.Mutex
+	consoleLoggers     = map[string]*consoleLogger{}
+)
+
+// consoleLogger copies a single VM's console stream to a log file on disk
+// until it is stopped or the stream is closed by libvirt.
+type consoleLogger struct {
+	stop   chan struct{}
+	done   chan struct{}
+	stream io.Closer
+
+	// closeOnce guards stream.Close, which both stopConsoleLogger (to
+	// unblock a Read in progress) and the copy goroutine itself (on exit)
+	// may call.
+	closeOnce sync.Once
+}
+
+// close closes the console stream, unblocking any Read currently in
+// progress on it. It is safe to call more than once or concurrently.
+func (cl *consoleLogger) close() {
+	cl.closeOnce.Do(func() {
+		cl.stream.Close()
+	})
+}
+
+// consoleLogPath returns the path of the persisted console log for a VM.
+func (v *Virter) consoleLogPath(vmName string) string {
+	return filepath.Join(v.consoleLogDir, vmName+"-console.log")
+}
+
+// startConsoleLogger opens the VM's serial console and starts a goroutine
+// that appends its output to <pool>/<vm>-console.log, rotating the file
+// once it exceeds consoleLogRetention bytes. It is a no-op if a logger for
+// the VM is already running.
+func (v *Virter) startConsoleLogger(vmName string) error {
+	consoleLoggersLock.Lock()
+	defer consoleLoggersLock.Unlock()
+
+	if _, ok := consoleLoggers[vmName]; ok {
+		return nil
+	}
+
+	domain, err := v.libvirt.DomainLookupByName(vmName)
+	if err != nil {
+		return fmt.Errorf("could not get domain: %w", err)
+	}
+
+	stream, err := v.libvirt.NewStream(0)
+	if err != nil {
+		return fmt.Errorf("could not create console stream: %w", err)
+	}
+
+	if err := v.libvirt.DomainOpenConsole(domain, "", stream, libvirt.DomainConsoleForce); err != nil {
+		return fmt.Errorf("could not open console: %w", err)
+	}
+
+	logFile, err := os.OpenFile(v.consoleLogPath(vmName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open console log file: %w", err)
+	}
+
+	cl := &consoleLogger{
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		stream: stream,
+	}
+	consoleLoggers[vmName] = cl
+
+	log.Printf("Attaching console log for %v", vmName)
+
+	go func() {
+		defer close(cl.done)
+		defer logFile.Close()
+		defer cl.close()
+		runConsoleCopyLoop(cl.stop, stream, logFile, v.consoleLogPath(vmName))
+	}()
+
+	return nil
+}
+
+// runConsoleCopyLoop copies from the console stream to the log file,
+// rotating the file once it grows past consoleLogRetention, until stop is
+// closed or the stream returns an error (typically because the VM shut
+// down or the console was closed by stopConsoleLogger).
+func runConsoleCopyLoop(stop <-chan struct{}, stream io.Reader, logFile *os.File, logPath string) {
+	buf := make([]byte, 4096)
+	var written int64
+	if fi, err := logFile.Stat(); err == nil {
+		written = fi.Size()
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		n, err := stream.Read(buf)
+		if n > 0 {
+			if _, werr := logFile.Write(buf[:n]); werr != nil {
+				log.Printf("could not write console log: %v", werr)
+				return
+			}
+			written += int64(n)
+			if written > consoleLogRetention {
+				if rerr := rotateConsoleLog(logFile, logPath); rerr != nil {
+					log.Printf("could not rotate console log: %v", rerr)
+				}
+				written = 0
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("console stream for %v closed: %v", logPath, err)
+			}
+			return
+		}
+	}
+}
+
+// rotateConsoleLog copies the current log to a ".1" suffix, replacing any
+// earlier rotated log, and truncates logFile so writes continue into a
+// fresh file at logPath.
+func rotateConsoleLog(logFile *os.File, logPath string) error {
+	data, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(logPath+".1", data, 0644); err != nil {
+		return err
+	}
+	return logFile.Truncate(0)
+}
+
+// stopConsoleLogger stops and removes the console logger for a VM, if one
+// is running. It is safe to call even if no logger is running.
+func (v *Virter) stopConsoleLogger(vmName string) {
+	consoleLoggersLock.Lock()
+	cl, ok := consoleLoggers[vmName]
+	if ok {
+		delete(consoleLoggers, vmName)
+	}
+	consoleLoggersLock.Unlock()
+
+	if !ok {
+		return
+	}
+
+	close(cl.stop)
+	// A Read already in progress on the stream won't notice cl.stop until
+	// its next iteration, which may never come if no more console output
+	// or EOF arrives (e.g. the VM is still running). Closing the stream
+	// directly unblocks it immediately.
+	cl.close()
+	<-cl.done
+}
+
+// VMConsoleAttach opens the VM's serial console for interactive use and
+// returns a stream that can be copied to/from a terminal. The caller must
+// close the returned stream when done.
+func (v *Virter) VMConsoleAttach(vmName string) (io.ReadWriteCloser, error) {
+	domain, err := v.libvirt.DomainLookupByName(vmName)
+	if err != nil {
+		return nil, fmt.Errorf("could not get domain: %w", err)
+	}
+
+	stream, err := v.libvirt.NewStream(0)
+	if err != nil {
+		return nil, fmt.Errorf("could not create console stream: %w", err)
+	}
+
+	if err := v.libvirt.DomainOpenConsole(domain, "", stream, libvirt.DomainConsoleForce); err != nil {
+		stream.Close()
+		return nil, fmt.Errorf("could not open console: %w", err)
+	}
+
+	return stream, nil
+}
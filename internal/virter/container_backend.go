@@ -0,0 +1,166 @@
+package virter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+)
+
+// vmidLabel is set on every container-backed VM's container so that
+// rmContainerVM can recover its VMID (and hence its MAC/DHCP entry)
+// without needing the original VMConfig.
+const vmidLabel = "virter.vmid"
+
+// BackendLibvirt and BackendContainer are the supported values for
+// VMConfig.Backend. An empty Backend is treated as BackendLibvirt, so
+// existing callers are unaffected.
+const (
+	BackendLibvirt   = "libvirt"
+	BackendContainer = "container"
+)
+
+// containerName is the name given to the Docker container backing a
+// container-mode VM, so VMRm can find it again by name alone.
+func containerName(vmName string) string {
+	return "virter-" + vmName
+}
+
+// createContainerVM launches vmConfig.ImageName as a Docker container,
+// attaches it to the libvirt-managed bridge network and registers a DHCP
+// entry for it, and returns the reserved IP. The container runs the
+// image's own default command; nothing here starts an SSH daemon. getIPs,
+// and with it VMExecShell, VMExecRsync and VMSSHSession, looks VMs up as
+// libvirt domains and does not find a container-backed VM.
+func (v *Virter) createContainerVM(vmConfig VMConfig) (net.IP, error) {
+	vmName := vmConfig.VMName
+	mac := qemuMAC(vmConfig.VMID)
+
+	ip, err := v.addDHCPEntry(mac, vmConfig.VMID)
+	if err != nil {
+		return nil, err
+	}
+
+	docker, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to Docker: %w", err)
+	}
+	defer docker.Close()
+
+	ctx := context.Background()
+
+	// The network is attached at creation time via NetworkMode +
+	// EndpointsConfig, with the MAC pinned to match the DHCP reservation
+	// above; a separate NetworkConnect call would just fail, since the
+	// container is already attached to this network by then.
+	networkingConfig := &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			v.networkName: {MacAddress: mac},
+		},
+	}
+
+	resp, err := docker.ContainerCreate(ctx, &container.Config{
+		Image:  vmConfig.ImageName,
+		Labels: map[string]string{vmidLabel: strconv.Itoa(vmConfig.VMID)},
+	}, &container.HostConfig{
+		NetworkMode: container.NetworkMode(v.networkName),
+		Privileged:  true,
+	}, networkingConfig, nil, containerName(vmName))
+	if err != nil {
+		return nil, fmt.Errorf("could not create container: %w", err)
+	}
+
+	log.Print("Start container")
+	if err := docker.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return nil, fmt.Errorf("could not start container: %w", err)
+	}
+
+	return ip, nil
+}
+
+// rmDHCPEntryByMAC removes a DHCP host entry by MAC address directly,
+// without going through a libvirt domain. rmDHCPEntry (used for
+// libvirt-backed VMs) reads the MAC off the domain first and then takes
+// the same path; container-backed VMs have no domain to read it from.
+func (v *Virter) rmDHCPEntryByMAC(mac string) error {
+	network, err := v.libvirt.NetworkLookupByName(v.networkName)
+	if err != nil {
+		return fmt.Errorf("could not get network: %w", err)
+	}
+
+	xml := fmt.Sprintf(`<host mac='%s'/>`, mac)
+
+	err = v.libvirt.NetworkUpdate(
+		network,
+		uint32(libvirt.NetworkUpdateCommandDelete),
+		uint32(libvirt.NetworkSectionIPDhcpHost),
+		-1,
+		[]string{xml},
+		libvirt.NetworkUpdateAffectLive|libvirt.NetworkUpdateAffectConfig,
+	)
+	if err != nil {
+		return fmt.Errorf("could not update network: %w", err)
+	}
+
+	return nil
+}
+
+// lookupContainerVM returns the container backing vmName, if any, and
+// whether it exists at all. It is only called once VMRm has already
+// established there is no libvirt domain by that name, so a host with no
+// Docker daemon at all (the common case for a pure-libvirt install) never
+// needs to reach this code for an ordinary VM removal. Any error
+// connecting to or querying Docker is treated the same as "not found"
+// rather than failing the removal outright, since by this point we
+// already know it isn't a libvirt-backed VM either.
+func (v *Virter) lookupContainerVM(vmName string) (types.ContainerJSON, bool) {
+	docker, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return types.ContainerJSON{}, false
+	}
+	defer docker.Close()
+
+	info, err := docker.ContainerInspect(context.Background(), containerName(vmName))
+	if err != nil {
+		return types.ContainerJSON{}, false
+	}
+
+	return info, true
+}
+
+// rmContainerVM stops and removes the Docker container backing vmName, as
+// well as its DHCP entry, mirroring what vmRmExceptBoot does for a
+// libvirt-backed VM.
+func (v *Virter) rmContainerVM(vmName string, info types.ContainerJSON) error {
+	vmID, err := strconv.Atoi(info.Config.Labels[vmidLabel])
+	if err != nil {
+		return fmt.Errorf("could not recover VMID from container: %w", err)
+	}
+
+	if err := v.rmDHCPEntryByMAC(qemuMAC(vmID)); err != nil {
+		return fmt.Errorf("could not remove DHCP entry: %w", err)
+	}
+
+	docker, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return fmt.Errorf("could not connect to Docker: %w", err)
+	}
+	defer docker.Close()
+
+	log.Print("Remove container")
+	err = docker.ContainerRemove(context.Background(), info.ID, types.ContainerRemoveOptions{Force: true})
+	if err != nil && !client.IsErrNotFound(err) {
+		return fmt.Errorf("could not remove container: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package virter
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// FleetVMOverride holds per-VM overrides applied on top of the fleet's
+// shared defaults.
+type FleetVMOverride struct {
+	MemoryKiB uint64
+	VCPUs     uint
+
+	// CloudInitUserDataOverlay, if set, is appended to this VM's
+	// rendered cloud-init user-data.
+	CloudInitUserDataOverlay string
+}
+
+// FleetSpec is a declarative description of a set of VMs to bring up
+// together, as built from a YAML fleet manifest by the fleet command.
+// TOML manifests are not read.
+type FleetSpec struct {
+	// NamePrefix is prepended to the index of each VM to form its name,
+	// e.g. NamePrefix "etcd" with Count 3 yields etcd-0, etcd-1, etcd-2.
+	NamePrefix string
+	Count      int
+	ImageName  string
+	MemoryKiB  uint64
+	VCPUs      uint
+
+	// Overrides holds per-index overrides, keyed by VM index.
+	Overrides map[int]FleetVMOverride
+
+	// MaxParallel bounds how many VMs are provisioned concurrently. A
+	// value <= 0 means unbounded.
+	MaxParallel int
+
+	// BaseVMID is the VMID assigned to index 0; subsequent VMs get
+	// BaseVMID+index, giving each fleet member a unique MAC/IP.
+	BaseVMID int
+
+	// SSHPublicKeys is installed into every fleet VM's cloud-init
+	// user-data, the same way the single-VM path authorizes a key for
+	// VMExecShell/VMFleetProvision to SSH in with.
+	SSHPublicKeys []string
+}
+
+// vmConfig returns the VMConfig for the VM at the given index within the
+// fleet, with shared defaults and any per-index override applied.
+func (f FleetSpec) vmConfig(index int, vmID int) VMConfig {
+	memKiB := f.MemoryKiB
+	vcpus := f.VCPUs
+
+	if o, ok := f.Overrides[index]; ok {
+		if o.MemoryKiB != 0 {
+			memKiB = o.MemoryKiB
+		}
+		if o.VCPUs != 0 {
+			vcpus = o.VCPUs
+		}
+	}
+
+	return VMConfig{
+		ImageName:                f.ImageName,
+		VMName:                   fmt.Sprintf("%s-%d", f.NamePrefix, index),
+		VMID:                     vmID,
+		MemoryKiB:                memKiB,
+		VCPUs:                    vcpus,
+		SSHPublicKeys:            f.SSHPublicKeys,
+		CloudInitUserDataOverlay: f.Overrides[index].CloudInitUserDataOverlay,
+	}
+}
+
+// VMFleetRun brings up every VM described by spec in parallel, bounded by
+// spec.MaxParallel. If any VM fails to start or reach SSH, every VM in the
+// fleet that was already created is torn down again, unless keepOnFailure
+// is set.
+func (v *Virter) VMFleetRun(ctx context.Context, g ISOGenerator, waiter PortWaiter, spec FleetSpec, waitSSH bool, keepOnFailure bool) error {
+	sem := newSemaphore(spec.MaxParallel)
+
+	eg, ctx := errgroup.WithContext(ctx)
+	created := make([]string, spec.Count)
+
+	for i := 0; i < spec.Count; i++ {
+		i := i
+		vmConfig := spec.vmConfig(i, spec.BaseVMID+i)
+		created[i] = vmConfig.VMName
+
+		eg.Go(func() error {
+			if err := sem.Acquire(ctx); err != nil {
+				return err
+			}
+			defer sem.Release()
+
+			log.Printf("Fleet: starting VM %v", vmConfig.VMName)
+			return v.VMRun(ctx, g, waiter, vmConfig, waitSSH)
+		})
+	}
+
+	runErr := eg.Wait()
+	if runErr != nil && !keepOnFailure {
+		log.Printf("Fleet provisioning failed, tearing down fleet: %v", runErr)
+		v.rollbackFleet(created)
+	}
+
+	return runErr
+}
+
+// fleetVMNames returns the VM names for every member of the fleet.
+func (f FleetSpec) fleetVMNames() []string {
+	names := make([]string, f.Count)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s-%d", f.NamePrefix, i)
+	}
+	return names
+}
+
+// VMFleetProvision runs the given shell provisioning step against every VM
+// in the fleet via VMExecShell, on at most spec.MaxParallel VMs at a time.
+// On failure, the whole fleet is torn down unless keepOnFailure is set.
+func (v *Virter) VMFleetProvision(ctx context.Context, spec FleetSpec, sshPrivateKey []byte, shellStep *ProvisionShellStep, keepOnFailure bool) error {
+	vmNames := spec.fleetVMNames()
+	sem := newSemaphore(spec.MaxParallel)
+
+	eg, egCtx := errgroup.WithContext(ctx)
+	for _, vmName := range vmNames {
+		vmName := vmName
+		eg.Go(func() error {
+			if err := sem.Acquire(egCtx); err != nil {
+				return err
+			}
+			defer sem.Release()
+
+			return v.VMExecShell(egCtx, []string{vmName}, sshPrivateKey, shellStep)
+		})
+	}
+
+	err := eg.Wait()
+	if err != nil && !keepOnFailure {
+		log.Printf("Fleet provisioning step failed, tearing down fleet: %v", err)
+		v.rollbackFleet(vmNames)
+	}
+
+	return err
+}
+
+// rollbackFleet removes every named VM, logging (rather than failing on)
+// individual removal errors so that one stuck VM does not prevent cleanup
+// of the rest of the fleet.
+func (v *Virter) rollbackFleet(vmNames []string) {
+	for _, vmName := range vmNames {
+		if err := v.VMRm(vmName); err != nil {
+			log.Printf("could not remove VM %v during fleet rollback: %v", vmName, err)
+		}
+	}
+}
+
+// semaphore bounds concurrency to at most n goroutines at a time. A
+// non-positive n means unbounded.
+type semaphore struct {
+	tokens chan struct{}
+}
+
+func newSemaphore(n int) *semaphore {
+	if n <= 0 {
+		return &semaphore{}
+	}
+	return &semaphore{tokens: make(chan struct{}, n)}
+}
+
+func (s *semaphore) Acquire(ctx context.Context) error {
+	if s.tokens == nil {
+		return nil
+	}
+	select {
+	case s.tokens <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *semaphore) Release() {
+	if s.tokens == nil {
+		return
+	}
+	<-s.tokens
+}
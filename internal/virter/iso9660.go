@@ -0,0 +1,291 @@
+package virter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"unicode/utf16"
+)
+
+// sectorSize is the logical block size used throughout an ISO9660 image.
+const sectorSize = 2048
+
+// builtinISOGenerator builds a minimal ISO9660 image with a Joliet
+// supplementary volume descriptor, without shelling out to
+// genisoimage/mkisofs. It only supports a flat set of files in the root
+// directory, which is all cidata images (meta-data, user-data, ...) need.
+type builtinISOGenerator struct{}
+
+// Generate implements ISOGenerator.
+func (builtinISOGenerator) Generate(files map[string][]byte) ([]byte, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Sector layout: 16 sectors of system area, then one sector each for
+	// the PVD, the Joliet SVD, the volume descriptor set terminator, the
+	// two path tables and the two root directory extents (one per
+	// directory tree), then the file data.
+	const (
+		systemAreaSectors = 16
+		pvdSector         = systemAreaSectors
+		svdSector         = pvdSector + 1
+		terminatorSector  = svdSector + 1
+		pathTableLSector  = terminatorSector + 1
+		pathTableMSector  = pathTableLSector + 1
+		rootExtent        = pathTableMSector + 1
+		jolietRootExtent  = rootExtent + 1
+		firstFileExtent   = jolietRootExtent + 1
+		rootSize          = sectorSize
+	)
+
+	entries := make([]isoFileEntry, len(names))
+	extent := uint32(firstFileExtent)
+	for i, name := range names {
+		data := files[name]
+		entries[i] = isoFileEntry{
+			name:   name,
+			data:   data,
+			extent: extent,
+			size:   uint32(len(data)),
+		}
+		extent += sectorCount(uint32(len(data)))
+	}
+	totalSectors := extent
+
+	pathTable := pathTableL(rootExtent)
+	pathTableSize := uint32(len(pathTable))
+
+	var buf bytes.Buffer
+
+	buf.Write(make([]byte, systemAreaSectors*sectorSize))
+
+	buf.Write(primaryVolumeDescriptor(rootExtent, rootSize, totalSectors, pathTableSize, pathTableLSector, pathTableMSector))
+	buf.Write(jolietVolumeDescriptor(jolietRootExtent, rootSize, totalSectors))
+	buf.Write(volumeDescriptorSetTerminator())
+
+	buf.Write(padSector(pathTable))
+	buf.Write(padSector(pathTableM(rootExtent)))
+
+	buf.Write(padSector(rootDirectoryRecords(rootExtent, entries)))
+	buf.Write(padSector(jolietRootDirectoryRecords(jolietRootExtent, entries)))
+
+	for _, e := range entries {
+		buf.Write(padSector(e.data))
+	}
+
+	return buf.Bytes(), nil
+}
+
+type isoFileEntry struct {
+	name   string
+	data   []byte
+	extent uint32
+	size   uint32
+}
+
+func sectorCount(size uint32) uint32 {
+	return (size + sectorSize - 1) / sectorSize
+}
+
+func padSector(data []byte) []byte {
+	padded := make([]byte, sectorCount(uint32(len(data)))*sectorSize)
+	copy(padded, data)
+	if len(padded) == 0 {
+		padded = make([]byte, sectorSize)
+	}
+	return padded
+}
+
+// bothEndian32 encodes v as both little-endian and big-endian, as required
+// by most multi-byte ISO9660 fields ("both-byte order" in ECMA-119).
+func bothEndian32(v uint32) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint32(b[0:4], v)
+	binary.BigEndian.PutUint32(b[4:8], v)
+	return b
+}
+
+func bothEndian16(v uint16) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint16(b[0:2], v)
+	binary.BigEndian.PutUint16(b[2:4], v)
+	return b
+}
+
+func isoDate(b []byte) {
+	// All-zero date fields mean "not specified", which is valid and
+	// avoids depending on a wall-clock time source.
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+func padString(s string, n int, pad byte) []byte {
+	b := bytes.Repeat([]byte{pad}, n)
+	copy(b, s)
+	return b
+}
+
+func primaryVolumeDescriptor(rootExtent, rootSize, totalSectors, pathTableSize, pathTableLExtent, pathTableMExtent uint32) []byte {
+	b := make([]byte, sectorSize)
+
+	b[0] = 1 // volume descriptor type: primary
+	copy(b[1:6], "CD001")
+	b[6] = 1 // version
+
+	copy(b[40:72], padString("CIDATA", 32, ' '))
+
+	copy(b[80:88], bothEndian32(totalSectors))
+	copy(b[120:124], bothEndian16(1)[0:4]) // volume set size
+	copy(b[124:128], bothEndian16(1)[0:4]) // volume sequence number
+	copy(b[128:132], bothEndian16(uint16(sectorSize))[0:4])
+
+	copy(b[132:140], bothEndian32(pathTableSize))
+	binary.LittleEndian.PutUint32(b[140:144], pathTableLExtent)
+	binary.BigEndian.PutUint32(b[148:152], pathTableMExtent)
+
+	root := directoryRecord(rootExtent, rootSize, ".", true)
+	copy(b[156:156+len(root)], root)
+
+	dateOffset := 813
+	isoDate(b[dateOffset : dateOffset+17])
+
+	b[881] = 1 // file structure version
+
+	return b
+}
+
+func jolietVolumeDescriptor(rootExtent, rootSize, totalSectors uint32) []byte {
+	b := make([]byte, sectorSize)
+
+	b[0] = 2 // supplementary volume descriptor
+	copy(b[1:6], "CD001")
+	b[6] = 1
+
+	copy(b[88:120], []byte{0x25, 0x2F, 0x45}) // Joliet escape sequence, UCS-2 level 3
+
+	copy(b[80:88], bothEndian32(totalSectors))
+	copy(b[128:132], bothEndian16(uint16(sectorSize))[0:4])
+
+	root := directoryRecord(rootExtent, rootSize, ".", true)
+	copy(b[156:156+len(root)], root)
+
+	b[881] = 1
+
+	return b
+}
+
+func volumeDescriptorSetTerminator() []byte {
+	b := make([]byte, sectorSize)
+	b[0] = 255
+	copy(b[1:6], "CD001")
+	b[6] = 1
+	return b
+}
+
+// directoryRecord builds a single directory record entry for name,
+// pointing at extent/size. isDir marks "." and ".." self-referential
+// entries used in the volume descriptors and as the first two entries of
+// every directory listing.
+func directoryRecord(extent, size uint32, name string, isDir bool) []byte {
+	nameBytes := []byte(name)
+	if name == "." {
+		nameBytes = []byte{0}
+	} else if name == ".." {
+		nameBytes = []byte{1}
+	}
+
+	recLen := 33 + len(nameBytes)
+	if recLen%2 != 0 {
+		recLen++
+	}
+
+	b := make([]byte, recLen)
+	b[0] = byte(recLen)
+	copy(b[2:10], bothEndian32(extent))
+	copy(b[10:18], bothEndian32(size))
+	isoDate(b[18:25])
+	if isDir {
+		b[25] = 0x02
+	}
+	b[32] = byte(len(nameBytes))
+	copy(b[33:33+len(nameBytes)], nameBytes)
+
+	return b
+}
+
+func fileIdentifier(name string) string {
+	return name + ";1"
+}
+
+func rootDirectoryRecords(rootExtent uint32, entries []isoFileEntry) []byte {
+	var buf bytes.Buffer
+	buf.Write(directoryRecord(rootExtent, sectorSize, ".", true))
+	buf.Write(directoryRecord(rootExtent, sectorSize, "..", true))
+	for _, e := range entries {
+		buf.Write(directoryRecord(e.extent, e.size, fileIdentifier(e.name), false))
+	}
+	return buf.Bytes()
+}
+
+func jolietDirectoryRecord(extent, size uint32, name string, isDir bool) []byte {
+	var nameBytes []byte
+	if name == "." || name == ".." {
+		return directoryRecord(extent, size, name, isDir)
+	}
+	for _, r := range utf16.Encode([]rune(name)) {
+		nameBytes = append(nameBytes, byte(r>>8), byte(r))
+	}
+
+	recLen := 33 + len(nameBytes)
+	if recLen%2 != 0 {
+		recLen++
+	}
+
+	b := make([]byte, recLen)
+	b[0] = byte(recLen)
+	copy(b[2:10], bothEndian32(extent))
+	copy(b[10:18], bothEndian32(size))
+	isoDate(b[18:25])
+	if isDir {
+		b[25] = 0x02
+	}
+	b[32] = byte(len(nameBytes))
+	copy(b[33:33+len(nameBytes)], nameBytes)
+
+	return b
+}
+
+func jolietRootDirectoryRecords(rootExtent uint32, entries []isoFileEntry) []byte {
+	var buf bytes.Buffer
+	buf.Write(directoryRecord(rootExtent, sectorSize, ".", true))
+	buf.Write(directoryRecord(rootExtent, sectorSize, "..", true))
+	for _, e := range entries {
+		buf.Write(jolietDirectoryRecord(e.extent, e.size, e.name, false))
+	}
+	return buf.Bytes()
+}
+
+// pathTableL is the little-endian path table; with a single root
+// directory it only ever has one entry.
+func pathTableL(rootExtent uint32) []byte {
+	b := make([]byte, 10)
+	b[0] = 1 // name length
+	binary.LittleEndian.PutUint32(b[2:6], rootExtent)
+	binary.LittleEndian.PutUint16(b[6:8], 1) // parent directory number
+	b[8] = 0                                 // name: root (0x00)
+	return b
+}
+
+// pathTableM is the big-endian twin of pathTableL.
+func pathTableM(rootExtent uint32) []byte {
+	b := make([]byte, 10)
+	b[0] = 1
+	binary.BigEndian.PutUint32(b[2:6], rootExtent)
+	binary.BigEndian.PutUint16(b[6:8], 1)
+	b[8] = 0
+	return b
+}
@@ -0,0 +1,80 @@
+package virter
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestBuiltinISOGeneratorStructure(t *testing.T) {
+	files := map[string][]byte{
+		"meta-data": []byte("instance-id: test\n"),
+		"user-data": []byte("#cloud-config\n"),
+	}
+
+	data, err := builtinISOGenerator{}.Generate(files)
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+
+	if len(data)%sectorSize != 0 {
+		t.Fatalf("image size %d is not a multiple of the sector size", len(data))
+	}
+
+	pvd := sector(t, data, 16)
+	if pvd[0] != 1 {
+		t.Fatalf("sector 16 is not a primary volume descriptor: type %d", pvd[0])
+	}
+	if string(pvd[1:6]) != "CD001" {
+		t.Fatalf("sector 16 has wrong standard identifier: %q", pvd[1:6])
+	}
+
+	svd := sector(t, data, 17)
+	if svd[0] != 2 {
+		t.Fatalf("sector 17 is not a supplementary volume descriptor: type %d", svd[0])
+	}
+
+	term := sector(t, data, 18)
+	if term[0] != 255 {
+		t.Fatalf("sector 18 is not a volume descriptor set terminator: type %d", term[0])
+	}
+
+	pathTableSize := binary.LittleEndian.Uint32(pvd[132:136])
+	pathTableLExtent := binary.LittleEndian.Uint32(pvd[140:144])
+	pathTableMExtent := binary.BigEndian.Uint32(pvd[148:152])
+
+	if pathTableSize == 0 {
+		t.Fatal("path table size is zero")
+	}
+	if pathTableLExtent == 0 || pathTableMExtent == 0 {
+		t.Fatalf("path table pointers are unset: L=%d M=%d", pathTableLExtent, pathTableMExtent)
+	}
+
+	pathTableL := sector(t, data, int(pathTableLExtent))
+	if pathTableL[0] != 1 {
+		t.Fatalf("path table entry has wrong name length: %d", pathTableL[0])
+	}
+
+	volumeSpaceSizeLE := binary.LittleEndian.Uint32(pvd[80:84])
+	volumeSpaceSizeBE := binary.BigEndian.Uint32(pvd[84:88])
+	if volumeSpaceSizeLE != volumeSpaceSizeBE {
+		t.Fatalf("volume space size LE/BE mismatch: %d != %d", volumeSpaceSizeLE, volumeSpaceSizeBE)
+	}
+	if int(volumeSpaceSizeLE)*sectorSize != len(data) {
+		t.Fatalf("volume space size %d sectors does not match image size %d bytes", volumeSpaceSizeLE, len(data))
+	}
+
+	volumeSetSizeLE := binary.LittleEndian.Uint16(pvd[120:122])
+	volumeSetSizeBE := binary.BigEndian.Uint16(pvd[122:124])
+	if volumeSetSizeLE != 1 || volumeSetSizeBE != 1 {
+		t.Fatalf("volume set size not both-endian encoded: LE=%d BE=%d", volumeSetSizeLE, volumeSetSizeBE)
+	}
+}
+
+func sector(t *testing.T, data []byte, n int) []byte {
+	t.Helper()
+	start := n * sectorSize
+	if start+sectorSize > len(data) {
+		t.Fatalf("sector %d is out of range of a %d-byte image", n, len(data))
+	}
+	return data[start : start+sectorSize]
+}
@@ -0,0 +1,64 @@
+package virter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// externalISOGenerator shells out to genisoimage (or mkisofs, whichever
+// is on PATH) to build the cloud-init ISO, the way virter did before the
+// builtin pure-Go generator existed. It remains available under the name
+// "external" for hosts where the builtin generator doesn't suffice.
+type externalISOGenerator struct{}
+
+func init() {
+	RegisterISOGenerator("external", func() (ISOGenerator, error) {
+		return externalISOGenerator{}, nil
+	})
+}
+
+// Generate implements ISOGenerator.
+func (externalISOGenerator) Generate(files map[string][]byte) ([]byte, error) {
+	tool, err := genisoimageBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	srcDir, err := ioutil.TempDir("", "virter-cidata-src")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temp directory: %w", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(srcDir, name), data, 0644); err != nil {
+			return nil, fmt.Errorf("could not write %v: %w", name, err)
+		}
+	}
+
+	isoPath := filepath.Join(srcDir, "cidata.iso")
+
+	cmd := exec.Command(tool, "-output", isoPath, "-volid", "cidata", "-joliet", "-rock", srcDir)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%v failed: %w: %s", tool, err, out)
+	}
+
+	data, err := ioutil.ReadFile(isoPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read generated ISO: %w", err)
+	}
+
+	return data, nil
+}
+
+func genisoimageBinary() (string, error) {
+	for _, name := range []string{"genisoimage", "mkisofs"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("neither genisoimage nor mkisofs found on PATH")
+}
@@ -0,0 +1,53 @@
+package virter
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// ISOGeneratorFactory constructs an ISOGenerator. Factories are registered
+// by name via RegisterISOGenerator and selected at runtime, e.g. via the
+// `iso.generator` viper setting.
+type ISOGeneratorFactory func() (ISOGenerator, error)
+
+var isoGeneratorFactories = map[string]ISOGeneratorFactory{}
+
+// RegisterISOGenerator makes an ISOGenerator implementation available
+// under name for later lookup with NewISOGeneratorByName. Registration is
+// expected to happen from package init functions, so it panics on a
+// duplicate name rather than returning an error.
+func RegisterISOGenerator(name string, factory ISOGeneratorFactory) {
+	if _, ok := isoGeneratorFactories[name]; ok {
+		panic(fmt.Sprintf("ISO generator %q already registered", name))
+	}
+	isoGeneratorFactories[name] = factory
+}
+
+// NewISOGeneratorByName constructs the ISOGenerator registered under
+// name.
+func NewISOGeneratorByName(name string) (ISOGenerator, error) {
+	factory, ok := isoGeneratorFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown ISO generator %q", name)
+	}
+	return factory()
+}
+
+func init() {
+	RegisterISOGenerator("builtin", func() (ISOGenerator, error) {
+		return builtinISOGenerator{}, nil
+	})
+}
+
+// NewISOGenerator constructs the ISOGenerator selected by the
+// `iso.generator` viper setting ("builtin" or "external"), defaulting to
+// "builtin" so minimal CI containers without genisoimage/mkisofs keep
+// working out of the box.
+func NewISOGenerator() (ISOGenerator, error) {
+	name := viper.GetString("iso.generator")
+	if name == "" {
+		name = "builtin"
+	}
+	return NewISOGeneratorByName(name)
+}
@@ -0,0 +1,149 @@
+package virter
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Measurements maps PCR index to its measured hex digest.
+type Measurements map[int]string
+
+// tpmStateDir returns the per-VM directory swtpm uses to persist TPM
+// state, rooted under the same directory virter keeps other per-VM state
+// in.
+func (v *Virter) tpmStateDir(vmName string) string {
+	return filepath.Join(v.tpmStateRoot, vmName)
+}
+
+// ensureTPMState creates the swtpm state directory for a VM if it does
+// not already exist, so libvirt/swtpm have somewhere to persist the
+// emulated TPM's NVRAM across reboots.
+func (v *Virter) ensureTPMState(vmName string) error {
+	dir := v.tpmStateDir(vmName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("could not create TPM state directory: %w", err)
+	}
+	return nil
+}
+
+// rmTPMState deletes the swtpm state directory for a VM, if any. It is
+// called from vmRmExceptBoot so that removing a VM also removes its
+// emulated TPM's persisted state.
+func (v *Virter) rmTPMState(vmName string) error {
+	dir := v.tpmStateDir(vmName)
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("could not remove TPM state directory: %w", err)
+	}
+	return nil
+}
+
+// measureCommand is run in the guest via SSH to read back the PCR values
+// extended by firmware measured boot. tpm2-tools' YAML output is simpler
+// to parse reliably than talking to /dev/tpm0 directly from the host, and
+// doesn't require the host to reach into swtpm's opaque NVRAM blob, which
+// has no per-PCR representation to read.
+const measureCommand = "tpm2_pcrread sha256"
+
+// VMMeasure boots vmName headless, waits for it to come up, and reads
+// back the PCR values extended by firmware measured boot via an in-guest
+// agent (tpm2-tools) over SSH.
+func (v *Virter) VMMeasure(waiter PortWaiter, vmName string, sshPrivateKey []byte) (Measurements, error) {
+	domain, err := v.libvirt.DomainLookupByName(vmName)
+	if err != nil {
+		return nil, fmt.Errorf("could not get domain: %w", err)
+	}
+
+	active, err := v.libvirt.DomainIsActive(domain)
+	if err != nil {
+		return nil, fmt.Errorf("could not check if domain is active: %w", err)
+	}
+
+	if active == 0 {
+		if err := v.libvirt.DomainCreate(domain); err != nil {
+			return nil, fmt.Errorf("could not start domain: %w", err)
+		}
+	}
+
+	sshConfig, ips, err := v.getSSHClientConfig([]string{vmName}, sshPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := waiter.WaitPort(ips[0], "ssh"); err != nil {
+		return nil, fmt.Errorf("unable to connect to SSH port: %w", err)
+	}
+
+	out, err := sshCommandOutput(&sshConfig, net.JoinHostPort(ips[0], "22"), measureCommand)
+	if err != nil {
+		return nil, fmt.Errorf("could not read PCRs from guest: %w", err)
+	}
+
+	return parsePCRRead(out)
+}
+
+// sshCommandOutput runs a single non-interactive command over SSH and
+// returns its standard output.
+func sshCommandOutput(config *ssh.ClientConfig, ipPort string, command string) (string, error) {
+	client, err := ssh.Dial("tcp", ipPort, config)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	out, err := session.Output(command)
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+// parsePCRRead parses tpm2_pcrread's YAML-like output, e.g.:
+//
+//	sha256:
+//	  0 : 0x1234...
+//	  1 : 0xABCD...
+func parsePCRRead(output string) (Measurements, error) {
+	measurements := Measurements{}
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		index, digest, ok := parsePCRLine(line)
+		if !ok {
+			continue
+		}
+		measurements[index] = digest
+	}
+
+	if len(measurements) == 0 {
+		return nil, fmt.Errorf("no PCR values found in output")
+	}
+
+	return measurements, nil
+}
+
+func parsePCRLine(line string) (int, string, bool) {
+	parts := strings.SplitN(line, ":", 2)
+	if len(parts) != 2 {
+		return 0, "", false
+	}
+
+	index, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, "", false
+	}
+
+	return index, strings.TrimSpace(parts[1]), true
+}
@@ -0,0 +1,34 @@
+package virter
+
+import (
+	libvirt "github.com/digitalocean/go-libvirt"
+)
+
+// Virter manages VMs on top of a libvirt connection.
+type Virter struct {
+	libvirt         *libvirt.Libvirt
+	storagePoolName string
+	networkName     string
+
+	// consoleLogDir is the directory background console loggers persist
+	// per-VM console output to (<consoleLogDir>/<vm>-console.log).
+	consoleLogDir string
+
+	// tpmStateRoot is the directory swtpm state directories for TPM-backed
+	// VMs are created under, one subdirectory per VM.
+	tpmStateRoot string
+}
+
+// New creates a Virter that talks to libvirt over l, managing VM storage
+// in the pool storagePoolName and VM networking on networkName. Console
+// logs are written under consoleLogDir, and emulated TPM state under
+// tpmStateRoot.
+func New(l *libvirt.Libvirt, storagePoolName string, networkName string, consoleLogDir string, tpmStateRoot string) *Virter {
+	return &Virter{
+		libvirt:         l,
+		storagePoolName: storagePoolName,
+		networkName:     networkName,
+		consoleLogDir:   consoleLogDir,
+		tpmStateRoot:    tpmStateRoot,
+	}
+}
@@ -21,40 +21,69 @@ import (
 	libvirt "github.com/digitalocean/go-libvirt"
 )
 
-// VMRun starts a VM.
-func (v *Virter) VMRun(g ISOGenerator, waiter PortWaiter, vmConfig VMConfig, waitSSH bool) error {
-	sp, err := v.libvirt.StoragePoolLookupByName(v.storagePoolName)
-	if err != nil {
-		return fmt.Errorf("could not get storage pool: %w", err)
-	}
+// VMRun starts a VM. For a libvirt-backed VM, ctx is checked after each
+// volume is created and after the domain is defined and started; if it has
+// been canceled, whatever was already created is torn down again via
+// vmRmExceptBoot+rmVolume before VMRun returns ctx.Err(), so a Ctrl-C
+// during creation does not leak volumes or DHCP entries. Cancellation is
+// not checked during the SSH wait, and a container-backed VM is not torn
+// down on cancellation.
+func (v *Virter) VMRun(ctx context.Context, g ISOGenerator, waiter PortWaiter, vmConfig VMConfig, waitSSH bool) error {
+	var ip net.IP
+
+	if vmConfig.Backend == BackendContainer {
+		containerIP, err := v.createContainerVM(vmConfig)
+		if err != nil {
+			return err
+		}
+		ip = containerIP
+	} else {
+		sp, err := v.libvirt.StoragePoolLookupByName(v.storagePoolName)
+		if err != nil {
+			return fmt.Errorf("could not get storage pool: %w", err)
+		}
 
-	log.Print("Create boot volume")
-	err = v.createVMVolume(sp, vmConfig)
-	if err != nil {
-		return err
-	}
+		log.Print("Create boot volume")
+		if err := v.createVMVolume(sp, vmConfig); err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return v.rollbackVMRun(sp, vmConfig.VMName, err)
+		}
 
-	log.Print("Create cloud-init volume")
-	err = v.createCIData(sp, g, vmConfig)
-	if err != nil {
-		return err
-	}
+		log.Print("Create cloud-init volume")
+		if err := v.createCIData(sp, g, vmConfig); err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return v.rollbackVMRun(sp, vmConfig.VMName, err)
+		}
 
-	log.Print("Create scratch volume")
-	err = v.createScratchVolume(sp, vmConfig)
-	if err != nil {
-		return err
+		log.Print("Create scratch volume")
+		if err := v.createScratchVolume(sp, vmConfig); err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return v.rollbackVMRun(sp, vmConfig.VMName, err)
+		}
+
+		vmIP, err := v.createVM(sp, vmConfig)
+		if err != nil {
+			return err
+		}
+		ip = vmIP
+		if err := ctx.Err(); err != nil {
+			return v.rollbackVMRun(sp, vmConfig.VMName, err)
+		}
 	}
 
-	ip, err := v.createVM(sp, vmConfig)
-	if err != nil {
-		return err
+	if err := v.startConsoleLogger(vmConfig.VMName); err != nil {
+		log.Printf("could not attach console logger: %v", err)
 	}
 
 	if waitSSH {
 		log.Print("Wait for SSH port to open")
-		err = waiter.WaitPort(ip, "ssh")
-		if err != nil {
+		if err := waiter.WaitPort(ip, "ssh"); err != nil {
 			return fmt.Errorf("unable to connect to SSH port: %w", err)
 		}
 		log.Print("Successfully connected to SSH port")
@@ -63,6 +92,22 @@ func (v *Virter) VMRun(g ISOGenerator, waiter PortWaiter, vmConfig VMConfig, wai
 	return nil
 }
 
+// rollbackVMRun removes whatever VMRun had already created for vmName
+// before it was canceled, logging (rather than masking the cancellation
+// with) any cleanup error, and returns cancelErr so the caller reports the
+// cancellation itself.
+func (v *Virter) rollbackVMRun(sp libvirt.StoragePool, vmName string, cancelErr error) error {
+	log.Printf("VMRun canceled, removing partially-created VM %v: %v", vmName, cancelErr)
+
+	if err := v.vmRmExceptBoot(sp, vmName); err != nil {
+		log.Printf("could not clean up VM %v after cancellation: %v", vmName, err)
+	} else if err := v.rmVolume(sp, vmName, "boot"); err != nil {
+		log.Printf("could not clean up VM %v after cancellation: %v", vmName, err)
+	}
+
+	return cancelErr
+}
+
 func (v *Virter) createCIData(sp libvirt.StoragePool, g ISOGenerator, vmConfig VMConfig) error {
 	vmName := vmConfig.VMName
 	sshPublicKeys := vmConfig.SSHPublicKeys
@@ -72,7 +117,7 @@ func (v *Virter) createCIData(sp libvirt.StoragePool, g ISOGenerator, vmConfig V
 		return err
 	}
 
-	userData, err := v.userData(vmName, sshPublicKeys)
+	userData, err := v.userData(vmName, sshPublicKeys, vmConfig.CloudInitUserDataOverlay)
 	if err != nil {
 		return err
 	}
@@ -117,13 +162,22 @@ func (v *Virter) metaData(vmName string) (string, error) {
 	return v.renderTemplate(templateMetaData, templateData)
 }
 
-func (v *Virter) userData(vmName string, sshPublicKeys []string) (string, error) {
+func (v *Virter) userData(vmName string, sshPublicKeys []string, overlay string) (string, error) {
 	templateData := map[string]interface{}{
 		"VMName":        vmName,
 		"SSHPublicKeys": sshPublicKeys,
 	}
 
-	return v.renderTemplate(templateUserData, templateData)
+	rendered, err := v.renderTemplate(templateUserData, templateData)
+	if err != nil {
+		return "", err
+	}
+
+	if overlay == "" {
+		return rendered, nil
+	}
+
+	return rendered + "\n" + overlay, nil
 }
 
 func (v *Virter) ciDataVolumeXML(name string) (string, error) {
@@ -198,6 +252,9 @@ func (v *Virter) scratchVolumeXML(name string) (string, error) {
 	return v.renderTemplate(templateScratchVolume, templateData)
 }
 
+// createVM defines and starts a libvirt domain for vmConfig. It must not be
+// called for vmConfig.Backend == BackendContainer; VMRun dispatches to
+// createContainerVM before any libvirt storage pool setup happens.
 func (v *Virter) createVM(sp libvirt.StoragePool, vmConfig VMConfig) (net.IP, error) {
 	vmName := vmConfig.VMName
 	vmID := vmConfig.VMID
@@ -205,7 +262,13 @@ func (v *Virter) createVM(sp libvirt.StoragePool, vmConfig VMConfig) (net.IP, er
 	vcpus := vmConfig.VCPUs
 	mac := qemuMAC(vmID)
 
-	xml, err := v.vmXML(sp.Name, vmName, mac, memKiB, vcpus)
+	if vmConfig.TPM {
+		if err := v.ensureTPMState(vmName); err != nil {
+			return nil, err
+		}
+	}
+
+	xml, err := v.vmXML(sp.Name, vmName, mac, memKiB, vcpus, vmConfig.TPM, vmConfig.SecureBoot)
 	if err != nil {
 		return nil, err
 	}
@@ -233,13 +296,15 @@ func (v *Virter) createVM(sp libvirt.StoragePool, vmConfig VMConfig) (net.IP, er
 	return ip, nil
 }
 
-func (v *Virter) vmXML(poolName string, vmName string, mac string, memKiB uint64, vcpus uint) (string, error) {
+func (v *Virter) vmXML(poolName string, vmName string, mac string, memKiB uint64, vcpus uint, tpm bool, secureBoot bool) (string, error) {
 	templateData := map[string]interface{}{
-		"PoolName":  poolName,
-		"VMName":    vmName,
-		"MAC":       mac,
-		"MemoryKiB": memKiB,
-		"VCPUs":     vcpus,
+		"PoolName":   poolName,
+		"VMName":     vmName,
+		"MAC":        mac,
+		"MemoryKiB":  memKiB,
+		"VCPUs":      vcpus,
+		"TPM":        tpm,
+		"SecureBoot": secureBoot,
 	}
 
 	return v.renderTemplate(templateVM, templateData)
@@ -247,6 +312,19 @@ func (v *Virter) vmXML(poolName string, vmName string, mac string, memKiB uint64
 
 // VMRm removes a VM.
 func (v *Virter) VMRm(vmName string) error {
+	v.stopConsoleLogger(vmName)
+
+	_, domainErr := v.libvirt.DomainLookupByName(vmName)
+	if hasErrorCode(domainErr, errNoDomain) {
+		// No libvirt domain by this name: it may be a container-backed
+		// VM instead. Only now do we touch Docker at all, so a host
+		// without a Docker daemon is unaffected by ordinary libvirt VM
+		// removal.
+		if info, ok := v.lookupContainerVM(vmName); ok {
+			return v.rmContainerVM(vmName, info)
+		}
+	}
+
 	sp, err := v.libvirt.StoragePoolLookupByName(v.storagePoolName)
 	if err != nil {
 		return fmt.Errorf("could not get storage pool: %w", err)
@@ -319,6 +397,11 @@ func (v *Virter) vmRmExceptBoot(sp libvirt.StoragePool, vmName string) error {
 		return err
 	}
 
+	err = v.rmTPMState(vmName)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -358,15 +441,17 @@ func (v *Virter) rmVolume(sp libvirt.StoragePool, volumeName string, debugName s
 
 // VMCommit commits a VM to an image. If shutdown is true, a goroutine to watch
 // for events will be started. This goroutine will only terminate when the
-// libvirt connection is closed, so take care of leaking goroutines.
-func (v *Virter) VMCommit(afterNotifier AfterNotifier, vmName string, shutdown bool, shutdownTimeout time.Duration) error {
+// libvirt connection is closed, so take care of leaking goroutines. If ctx
+// is canceled while waiting for the VM to shut down, VMCommit returns
+// ctx.Err() without touching the VM's volumes.
+func (v *Virter) VMCommit(ctx context.Context, afterNotifier AfterNotifier, vmName string, shutdown bool, shutdownTimeout time.Duration) error {
 	domain, err := v.libvirt.DomainLookupByName(vmName)
 	if err != nil {
 		return fmt.Errorf("could not get domain: %w", err)
 	}
 
 	if shutdown {
-		err = v.vmShutdown(afterNotifier, shutdownTimeout, domain)
+		err = v.vmShutdown(ctx, afterNotifier, shutdownTimeout, domain)
 		if err != nil {
 			return err
 		}
@@ -394,7 +479,7 @@ func (v *Virter) VMCommit(afterNotifier AfterNotifier, vmName string, shutdown b
 	return nil
 }
 
-func (v *Virter) vmShutdown(afterNotifier AfterNotifier, shutdownTimeout time.Duration, domain libvirt.Domain) error {
+func (v *Virter) vmShutdown(ctx context.Context, afterNotifier AfterNotifier, shutdownTimeout time.Duration, domain libvirt.Domain) error {
 	events, err := v.libvirt.LifecycleEvents()
 	if err != nil {
 		return fmt.Errorf("could not start waiting for events: %w", err)
@@ -429,6 +514,8 @@ func (v *Virter) vmShutdown(afterNotifier AfterNotifier, shutdownTimeout time.Du
 			}
 		case <-timeout:
 			return fmt.Errorf("timed out waiting for domain to stop")
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
 